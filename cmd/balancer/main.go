@@ -1,279 +1,66 @@
 package main
 
 import (
+	"cloudru/internal/balancer"
 	"cloudru/internal/config"
+	"cloudru/internal/router"
 	"context"
 	"errors"
 	"flag"
+	"log"
 	"log/slog"
-	"math/rand"
 	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 )
 
-type BackendServer struct {
-	URL          *url.URL
-	ReverseProxy *httputil.ReverseProxy
-	IsAlive      bool
-	mu           sync.Mutex
-	activeConns  int
-}
-
-func (b *BackendServer) IncrementConn() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.activeConns++
-}
-
-func (b *BackendServer) DecrementConn() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.activeConns--
-}
-
-func (b *BackendServer) GetActiveConns() int {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	return b.activeConns
-}
-
-type BalancingAlgorithm int
-
-const (
-	RoundRobin BalancingAlgorithm = iota
-	LeastConnections
-	Random
-)
-
-func (a BalancingAlgorithm) String() string {
-	switch a {
-	case RoundRobin:
-		return "RoundRobin"
-	case LeastConnections:
-		return "LeastConnections"
-	case Random:
-		return "Random"
-	default:
-		return "Unknown"
-	}
-}
-
-type LoadBalancer struct {
-	backends  []*BackendServer
-	current   uint64
-	mu        sync.Mutex
-	log       *slog.Logger
-	algorithm BalancingAlgorithm
-}
-
-func NewLoadBalancer(backends []string, log *slog.Logger, algorithmstr string) *LoadBalancer {
-	var algorithm BalancingAlgorithm
-	if algorithmstr == "random" {
-		algorithm = Random
-	} else if algorithmstr == "leastconnections" {
-		algorithm = LeastConnections
-	} else {
-		algorithm = RoundRobin
-	}
-	lb := &LoadBalancer{log: log, algorithm: algorithm}
-	lb.log.Info("Using load balancer with", "algorithm", algorithmstr)
-	for _, backendUrl := range backends {
-		parsedUrl, err := url.Parse(backendUrl)
-		if err != nil {
-			lb.log.Error("Failed to parse backend URL:", "error", err)
-		}
-
-		proxy := httputil.NewSingleHostReverseProxy(parsedUrl)
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			lb.mu.Lock()
-			for _, b := range lb.backends {
-				if b.URL == parsedUrl {
-					b.IsAlive = false
-					break
-				}
-			}
-			lb.mu.Unlock()
-			lb.log.Info("Backend is not availiable", "url", parsedUrl)
-
-			if nextBackend := lb.GetNextBackend(); nextBackend != nil {
-				log.Info("Retrying request with next backend", "url", nextBackend.URL)
-				nextBackend.ReverseProxy.ServeHTTP(w, r)
-				return
-			}
-
-			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		}
-
-		lb.backends = append(lb.backends, &BackendServer{
-			URL:          parsedUrl,
-			ReverseProxy: proxy,
-			IsAlive:      true,
-		})
-	}
-	return lb
-}
-
-func (lb *LoadBalancer) GetNextBackend() *BackendServer {
-	lb.mu.Lock()
-	defer lb.mu.Unlock()
-
-	switch lb.algorithm {
-	case LeastConnections:
-		return lb.getLeastBusyBackend()
-	case Random:
-		return lb.getRandomBackend()
-	default:
-		return lb.getRoundRobinBackend()
-	}
-}
-
-// least busy algorithm
-func (lb *LoadBalancer) getLeastBusyBackend() *BackendServer {
-	var leastBusy *BackendServer
-	minConns := int(^uint(0) >> 1)
-
-	for _, backend := range lb.backends {
-		if !backend.IsAlive {
-			continue
-		}
-
-		conns := backend.GetActiveConns()
-		if conns < minConns {
-			leastBusy = backend
-			minConns = conns
-		}
-	}
-
-	if leastBusy == nil {
-		lb.log.Error("No healthy backends available")
-		return nil
-	}
-
-	return leastBusy
-}
-
-// random algorithm
-func (lb *LoadBalancer) getRandomBackend() *BackendServer {
-	var available []*BackendServer
-	for _, backend := range lb.backends {
-		if backend.IsAlive {
-			available = append(available, backend)
-		}
-	}
-
-	if len(available) == 0 {
-		lb.log.Error("No healthy backends available")
-		return nil
-	}
-
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return available[rng.Intn(len(available))]
-}
-
-// round robin algorithm
-func (lb *LoadBalancer) getRoundRobinBackend() *BackendServer {
-	start := lb.current
-	for {
-		backend := lb.backends[lb.current%uint64(len(lb.backends))]
-		lb.current++
-
-		if backend.IsAlive {
-			return backend
-		}
-
-		if lb.current == start {
-			break
-		}
-	}
-
-	lb.log.Error("No healthy backends available")
-	return nil
-}
-
-func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	backend := lb.GetNextBackend()
-	if backend == nil {
-		http.Error(w, "No available backends", http.StatusServiceUnavailable)
-		return
-	}
-
-	backend.IncrementConn()
-	defer backend.DecrementConn()
-
-	lb.log.Info("Forwarding request",
-		"url", backend.URL,
-		"algorithm", lb.algorithm.String(),
-		"active_conns", backend.GetActiveConns(),
-	)
-
-	backend.ReverseProxy.ServeHTTP(w, r)
-}
-
-func (lb *LoadBalancer) CheckBackendHealth(backend *BackendServer) bool {
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	resp, err := client.Get(backend.URL.String())
-	if err != nil {
-		lb.log.Debug("Health check failed",
-			"url", backend.URL,
-			"error", err,
-		)
-		return false
-	}
-	defer resp.Body.Close()
-
-	return resp.StatusCode < http.StatusBadRequest
-}
-
-func (lb *LoadBalancer) RunHealthChecks(ctx context.Context, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			lb.mu.Lock()
-			for _, backend := range lb.backends {
-				wasAlive := backend.IsAlive
-				nowAlive := lb.CheckBackendHealth(backend)
-
-				if wasAlive != nowAlive {
-					backend.IsAlive = nowAlive
-					status := "up"
-					if !nowAlive {
-						status = "down"
-					}
-					lb.log.Info("Backend status changed",
-						"url", backend.URL,
-						"status", status,
-					)
-				}
-			}
-			lb.mu.Unlock()
-		}
-	}
-}
-
 func main() {
-	var configPath string
-	flag.StringVar(&configPath, "config", "config.yaml", "configuration file")
+	var (
+		configPath     string
+		configSource   string
+		configURL      string
+		configInterval time.Duration
+	)
+	flag.StringVar(&configPath, "config", "config.yaml", "configuration file (used when -config-source=file)")
+	flag.StringVar(&configSource, "config-source", "file", "configuration source: file, env, or http")
+	flag.StringVar(&configURL, "config-url", "", "URL to poll for JSON configuration (used when -config-source=http)")
+	flag.DurationVar(&configInterval, "config-poll-interval", 30*time.Second, "how often to re-check an env or http configuration source")
 	flag.Parse()
-	cfg := config.MustLoad(configPath)
+
+	provider := loadConfigProvider(configSource, configPath, configURL, configInterval)
+	cfg := provider.Current()
 
 	log := mustMakeLogger(cfg.LogLevel)
+	switch p := provider.(type) {
+	case *config.FileProvider:
+		p.SetLogger(log)
+	case *config.EnvProvider:
+		p.SetLogger(log)
+	case *config.HTTPProvider:
+		p.SetLogger(log)
+	}
+
+	defaultLB := balancer.NewLoadBalancer(cfg.Backends, log, cfg.Algorithm, cfg.Cache, cfg.OutlierDetection, cfg.Retry)
+	pools := []*balancer.LoadBalancer{defaultLB}
+
+	var routes []router.Route
+	var routeLBs []*balancer.LoadBalancer
+	for _, routeCfg := range cfg.Routes {
+		routeLB := balancer.NewLoadBalancer(routeCfg.Backends, log, routeCfg.Algorithm, cfg.Cache, cfg.OutlierDetection, cfg.Retry)
+		pools = append(pools, routeLB)
+		routeLBs = append(routeLBs, routeLB)
+		routes = append(routes, router.Route{
+			Host:        routeCfg.Host,
+			PathPrefix:  routeCfg.PathPrefix,
+			StripPrefix: routeCfg.StripPrefix,
+			Handler:     routeLB,
+		})
+	}
 
-	lb := NewLoadBalancer(cfg.Backends, log, cfg.Algorithm)
+	rt := router.New(routes, defaultLB, log)
 
 	log.Info("Load balancer started on address", "address", cfg.Address)
 
@@ -285,11 +72,18 @@ func main() {
 	)
 	defer stop()
 
-	go lb.RunHealthChecks(ctx, 10*time.Second)
+	for _, pool := range pools {
+		go pool.RunHealthChecks(ctx, 10*time.Second)
+	}
+	go watchConfig(ctx, provider, defaultLB, routeLBs, log)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", defaultLB.StatusHandler())
+	mux.Handle("/", rt)
 
 	server := http.Server{
 		Addr:        cfg.Address,
-		Handler:     lb,
+		Handler:     mux,
 		BaseContext: func(_ net.Listener) context.Context { return ctx },
 	}
 
@@ -310,6 +104,58 @@ func main() {
 	}
 }
 
+// loadConfigProvider constructs the ConfigProvider selected by source,
+// exiting the process if it can't be reached. "env" and "http" poll every
+// interval rather than watching for pushed changes.
+func loadConfigProvider(source, path, url string, interval time.Duration) config.ConfigProvider {
+	switch source {
+	case "file":
+		return config.MustLoad(path)
+	case "env":
+		return config.MustLoadEnv(interval)
+	case "http":
+		if url == "" {
+			log.Fatal("-config-url is required when -config-source=http")
+		}
+		return config.MustLoadHTTP(url, interval)
+	default:
+		log.Fatalf("unknown -config-source %q: must be file, env, or http", source)
+		return nil
+	}
+}
+
+// watchConfig applies every configuration update the provider emits to the
+// default pool and each route's pool, matched to the route it was built
+// from by position. Routes themselves (host/path matching) are fixed at
+// startup; only backend lists and tuning parameters are hot-reloaded.
+func watchConfig(ctx context.Context, provider config.ConfigProvider, defaultLB *balancer.LoadBalancer, routeLBs []*balancer.LoadBalancer, log *slog.Logger) {
+	for cfg := range provider.Watch(ctx) {
+		log.Info("Applying updated configuration")
+
+		defaultLB.ApplyConfig(config.Config{
+			Backends:         cfg.Backends,
+			Algorithm:        cfg.Algorithm,
+			Cache:            cfg.Cache,
+			OutlierDetection: cfg.OutlierDetection,
+			Retry:            cfg.Retry,
+		})
+
+		for i, routeLB := range routeLBs {
+			if i >= len(cfg.Routes) {
+				break
+			}
+			routeCfg := cfg.Routes[i]
+			routeLB.ApplyConfig(config.Config{
+				Backends:         routeCfg.Backends,
+				Algorithm:        routeCfg.Algorithm,
+				Cache:            cfg.Cache,
+				OutlierDetection: cfg.OutlierDetection,
+				Retry:            cfg.Retry,
+			})
+		}
+	}
+}
+
 func mustMakeLogger(logLevel string) *slog.Logger {
 	var level slog.Level
 	switch logLevel {
@@ -4,14 +4,97 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"sync"
+	"time"
 )
 
 type BackendServer struct {
 	URL          *url.URL
 	ReverseProxy *httputil.ReverseProxy
 	IsAlive      bool
-	mu           sync.Mutex
-	activeConns  int
+	// Weight influences how large a share of traffic this backend
+	// receives relative to its peers; algorithms treat <= 0 as 1.
+	Weight int
+	// MaxConns caps concurrent requests to this backend; <= 0 sets no
+	// per-backend cap, leaving the pool-wide default (if any) in effect.
+	MaxConns   int
+	HealthPath string
+
+	mu          sync.Mutex
+	activeConns int
+	semCap      int
+	semUsed     int
+
+	cbMu             sync.Mutex
+	state            CircuitState
+	window           []bool
+	windowPos        int
+	consecutiveFails int
+	ejections        int
+	openUntil        time.Time
+	halfOpenInFlight int
+}
+
+// Available reports whether the backend is healthy, under its configured
+// connection limit, and not currently ejected by its circuit breaker.
+func (b *BackendServer) Available() bool {
+	if !b.IsAlive {
+		return false
+	}
+
+	b.mu.Lock()
+	saturated := b.semCap > 0 && b.semUsed >= b.semCap
+	b.mu.Unlock()
+	if saturated {
+		return false
+	}
+
+	return b.circuitAvailable()
+}
+
+// InitSemaphore sets the backend's connection-slot capacity to capacity via
+// TryAcquire/Release. capacity <= 0 leaves it unbounded. It may be called
+// again later, e.g. when a config reload changes MaxConns for an existing
+// backend: the cap is resized in place and any slots already reserved by
+// in-flight requests carry over unaffected.
+func (b *BackendServer) InitSemaphore(capacity int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.semCap = capacity
+}
+
+// TryAcquire reserves one of the backend's connection slots without
+// blocking, reporting whether a slot was available. Call it once per
+// dispatched request, right before forwarding, and release the slot with
+// Release once the request completes.
+func (b *BackendServer) TryAcquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.semCap <= 0 {
+		return true
+	}
+	if b.semUsed >= b.semCap {
+		return false
+	}
+	b.semUsed++
+	return true
+}
+
+// Release returns a slot reserved by a successful TryAcquire.
+func (b *BackendServer) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.semCap <= 0 || b.semUsed <= 0 {
+		return
+	}
+	b.semUsed--
+}
+
+// EffectiveWeight returns Weight, treating an unset or invalid weight as 1.
+func (b *BackendServer) EffectiveWeight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
 }
 
 func (b *BackendServer) IncrementConn() {
@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"time"
+)
+
+// CircuitState is the passive-health state of a backend, modeled on the
+// classic circuit-breaker pattern.
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// windowSize bounds how many recent outcomes feed the failure-ratio
+// calculation; older outcomes are overwritten.
+const windowSize = 50
+
+// maxHalfOpenProbes caps how many requests may be in flight to a
+// half-open backend at once.
+const maxHalfOpenProbes = 1
+
+// OutlierPolicy configures when a backend's circuit trips open and how long
+// it stays ejected.
+type OutlierPolicy struct {
+	Consecutive5xx   int
+	FailureRatio     float64
+	MinRequests      int
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// LatencyBudget, if set, makes any outcome slower than this count as
+	// a failure even if the response itself was successful.
+	LatencyBudget time.Duration
+}
+
+// circuitAvailable is a read-only check used while filtering candidates:
+// it reports whether the circuit is not currently ejecting b, without
+// consuming a half-open probe slot. Selection algorithms call this once per
+// backend per pick, so it must have no side effects.
+func (b *BackendServer) circuitAvailable() bool {
+	b.cbMu.Lock()
+	defer b.cbMu.Unlock()
+
+	return b.state != StateOpen || !time.Now().Before(b.openUntil)
+}
+
+// BeginAttempt reserves the right to actually dispatch a request to b. It
+// transitions Open -> HalfOpen once the ejection cool-down has elapsed and
+// limits HalfOpen to a small number of concurrent probes. Call it exactly
+// once per request, right before dispatch, and report the outcome back via
+// RecordOutcome.
+func (b *BackendServer) BeginAttempt() bool {
+	b.cbMu.Lock()
+	defer b.cbMu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = StateHalfOpen
+		b.halfOpenInFlight = 0
+		return b.takeHalfOpenSlotLocked()
+	case StateHalfOpen:
+		return b.takeHalfOpenSlotLocked()
+	default:
+		return true
+	}
+}
+
+func (b *BackendServer) takeHalfOpenSlotLocked() bool {
+	if b.halfOpenInFlight >= maxHalfOpenProbes {
+		return false
+	}
+	b.halfOpenInFlight++
+	return true
+}
+
+// RecordOutcome feeds the result of a request into the sliding window and
+// trips or resets the circuit breaker as the policy dictates.
+func (b *BackendServer) RecordOutcome(success bool, latency time.Duration, policy OutlierPolicy) {
+	if policy.LatencyBudget > 0 && latency > policy.LatencyBudget {
+		success = false
+	}
+
+	b.cbMu.Lock()
+	defer b.cbMu.Unlock()
+
+	if len(b.window) < windowSize {
+		b.window = append(b.window, success)
+	} else {
+		b.window[b.windowPos] = success
+		b.windowPos = (b.windowPos + 1) % windowSize
+	}
+
+	if success {
+		b.consecutiveFails = 0
+	} else {
+		b.consecutiveFails++
+	}
+
+	if b.state == StateHalfOpen {
+		if b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		if success {
+			b.closeLocked()
+		} else {
+			b.tripLocked(policy)
+		}
+		return
+	}
+
+	if b.shouldTripLocked(policy) {
+		b.tripLocked(policy)
+	}
+}
+
+func (b *BackendServer) shouldTripLocked(policy OutlierPolicy) bool {
+	if policy.Consecutive5xx > 0 && b.consecutiveFails >= policy.Consecutive5xx {
+		return true
+	}
+
+	total := len(b.window)
+	if policy.MinRequests <= 0 || total < policy.MinRequests || policy.FailureRatio <= 0 {
+		return false
+	}
+
+	failures := 0
+	for _, ok := range b.window {
+		if !ok {
+			failures++
+		}
+	}
+
+	return float64(failures)/float64(total) >= policy.FailureRatio
+}
+
+func (b *BackendServer) tripLocked(policy OutlierPolicy) {
+	ejection := policy.BaseEjectionTime
+	if b.ejections > 0 {
+		ejection *= 1 << uint(min(b.ejections, 20))
+	}
+	if policy.MaxEjectionTime > 0 && ejection > policy.MaxEjectionTime {
+		ejection = policy.MaxEjectionTime
+	}
+
+	b.state = StateOpen
+	b.openUntil = time.Now().Add(ejection)
+	b.ejections++
+	b.consecutiveFails = 0
+}
+
+func (b *BackendServer) closeLocked() {
+	b.state = StateClosed
+	b.ejections = 0
+	b.consecutiveFails = 0
+	b.window = nil
+	b.windowPos = 0
+}
+
+// CircuitState returns the backend's current breaker state.
+func (b *BackendServer) CircuitState() CircuitState {
+	b.cbMu.Lock()
+	defer b.cbMu.Unlock()
+	return b.state
+}
+
+// RecentErrors returns the number of failures in the current outcome window.
+func (b *BackendServer) RecentErrors() int {
+	b.cbMu.Lock()
+	defer b.cbMu.Unlock()
+
+	errors := 0
+	for _, ok := range b.window {
+		if !ok {
+			errors++
+		}
+	}
+	return errors
+}
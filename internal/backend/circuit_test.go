@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitTripsOnConsecutiveFailures(t *testing.T) {
+	b := &BackendServer{IsAlive: true}
+	policy := OutlierPolicy{Consecutive5xx: 3, BaseEjectionTime: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		b.RecordOutcome(false, 0, policy)
+	}
+	if !b.Available() {
+		t.Fatalf("expected backend to still be available before threshold")
+	}
+
+	b.RecordOutcome(false, 0, policy)
+	if b.Available() {
+		t.Fatalf("expected backend to be ejected after consecutive failures")
+	}
+	if got := b.CircuitState(); got != StateOpen {
+		t.Fatalf("expected state open, got %v", got)
+	}
+}
+
+func TestCircuitHalfOpenClosesOnSuccess(t *testing.T) {
+	b := &BackendServer{IsAlive: true}
+	policy := OutlierPolicy{Consecutive5xx: 1, BaseEjectionTime: time.Millisecond}
+
+	b.RecordOutcome(false, 0, policy)
+	if b.CircuitState() != StateOpen {
+		t.Fatalf("expected open state after trip")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.Available() {
+		t.Fatalf("expected cool-down to have elapsed")
+	}
+	if !b.BeginAttempt() {
+		t.Fatalf("expected half-open probe to be allowed")
+	}
+	if got := b.CircuitState(); got != StateHalfOpen {
+		t.Fatalf("expected half-open state, got %v", got)
+	}
+
+	b.RecordOutcome(true, 0, policy)
+	if got := b.CircuitState(); got != StateClosed {
+		t.Fatalf("expected breaker to close after successful probe, got %v", got)
+	}
+}
+
+func TestCircuitHalfOpenReopensOnFailure(t *testing.T) {
+	b := &BackendServer{IsAlive: true}
+	policy := OutlierPolicy{Consecutive5xx: 1, BaseEjectionTime: time.Millisecond}
+
+	b.RecordOutcome(false, 0, policy)
+	time.Sleep(5 * time.Millisecond)
+	b.BeginAttempt()
+	b.RecordOutcome(false, 0, policy)
+
+	if got := b.CircuitState(); got != StateOpen {
+		t.Fatalf("expected breaker to reopen after failed probe, got %v", got)
+	}
+}
+
+func TestCircuitFailureRatioTripsAfterMinRequests(t *testing.T) {
+	b := &BackendServer{IsAlive: true}
+	policy := OutlierPolicy{FailureRatio: 0.5, MinRequests: 4, BaseEjectionTime: time.Minute}
+
+	b.RecordOutcome(true, 0, policy)
+	b.RecordOutcome(false, 0, policy)
+	b.RecordOutcome(true, 0, policy)
+	if b.CircuitState() == StateOpen {
+		t.Fatalf("expected breaker closed before MinRequests reached")
+	}
+
+	b.RecordOutcome(false, 0, policy)
+	if got := b.CircuitState(); got != StateOpen {
+		t.Fatalf("expected breaker open once failure ratio threshold crossed, got %v", got)
+	}
+}
+
+func TestCircuitLatencyBudgetCountsAsFailure(t *testing.T) {
+	b := &BackendServer{IsAlive: true}
+	policy := OutlierPolicy{Consecutive5xx: 1, BaseEjectionTime: time.Minute, LatencyBudget: 10 * time.Millisecond}
+
+	b.RecordOutcome(true, 50*time.Millisecond, policy)
+
+	if got := b.CircuitState(); got != StateOpen {
+		t.Fatalf("expected slow success to count as failure and trip breaker, got %v", got)
+	}
+}
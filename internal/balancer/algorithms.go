@@ -3,55 +3,65 @@ package balancer
 import (
 	"cloudru/internal/backend"
 	"log/slog"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
 )
 
-type BalancingAlgorithm int
-
-const (
-	RoundRobin BalancingAlgorithm = iota
-	LeastConnections
-	Random
-)
+// Algorithm selects the next backend to serve a request from a candidate
+// list. excluded holds the URLs (backend.BackendServer.URL.String()) of
+// backends already tried for the current request, e.g. on a retry, and
+// must be skipped. Implementations must be safe for concurrent use.
+type Algorithm interface {
+	Name() string
+	GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer
+}
 
-func (a BalancingAlgorithm) String() string {
-	switch a {
-	case RoundRobin:
-		return "RoundRobin"
-	case LeastConnections:
-		return "LeastConnections"
-	case Random:
-		return "Random"
+// NewAlgorithm resolves a configured algorithm name to an Algorithm,
+// defaulting to round-robin for unrecognized values.
+func NewAlgorithm(name string) Algorithm {
+	switch name {
+	case "random":
+		return NewRandomAlgo()
+	case "leastconnections":
+		return &LeastConnectionsAlgo{}
+	case "weightedroundrobin":
+		return NewWeightedRoundRobinAlgo()
+	case "weightedleastconnections":
+		return &WeightedLeastConnectionsAlgo{}
 	default:
-		return "Unknown"
+		return &RoundRobinAlgo{}
 	}
 }
 
-type Algorithm interface {
-	GetNextBackend(backends []*backend.BackendServer, log *slog.Logger) *backend.BackendServer
-}
-
 type RoundRobinAlgo struct {
 	current uint64
 	mu      sync.Mutex
 }
 
-func (a *RoundRobinAlgo) GetNextBackend(backends []*backend.BackendServer, log *slog.Logger) *backend.BackendServer {
+func (a *RoundRobinAlgo) Name() string { return "roundrobin" }
+
+func (a *RoundRobinAlgo) GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if len(backends) == 0 {
+		log.Error("No backends configured")
+		return nil
+	}
+
 	start := a.current
 	for {
-		backend := backends[a.current%uint64(len(backends))]
+		idx := a.current % uint64(len(backends))
+		candidate := backends[idx]
 		a.current++
 
-		if backend.IsAlive {
-			return backend
+		if !excluded[candidate.URL.String()] && candidate.Available() {
+			return candidate
 		}
 
-		if a.current == start {
+		if a.current-start >= uint64(len(backends)) {
 			break
 		}
 	}
@@ -62,18 +72,20 @@ func (a *RoundRobinAlgo) GetNextBackend(backends []*backend.BackendServer, log *
 
 type LeastConnectionsAlgo struct{}
 
-func (a *LeastConnectionsAlgo) GetNextBackend(backends []*backend.BackendServer, log *slog.Logger) *backend.BackendServer {
+func (a *LeastConnectionsAlgo) Name() string { return "leastconnections" }
+
+func (a *LeastConnectionsAlgo) GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer {
 	var leastBusy *backend.BackendServer
 	minConns := int(^uint(0) >> 1)
 
-	for _, backend := range backends {
-		if !backend.IsAlive {
+	for _, b := range backends {
+		if excluded[b.URL.String()] || !b.Available() {
 			continue
 		}
 
-		conns := backend.GetActiveConns()
+		conns := b.GetActiveConns()
 		if conns < minConns {
-			leastBusy = backend
+			leastBusy = b
 			minConns = conns
 		}
 	}
@@ -97,11 +109,13 @@ func NewRandomAlgo() *RandomAlgo {
 	}
 }
 
-func (a *RandomAlgo) GetNextBackend(backends []*backend.BackendServer, log *slog.Logger) *backend.BackendServer {
+func (a *RandomAlgo) Name() string { return "random" }
+
+func (a *RandomAlgo) GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer {
 	var available []*backend.BackendServer
-	for _, backend := range backends {
-		if backend.IsAlive {
-			available = append(available, backend)
+	for _, b := range backends {
+		if !excluded[b.URL.String()] && b.Available() {
+			available = append(available, b)
 		}
 	}
 
@@ -116,3 +130,80 @@ func (a *RandomAlgo) GetNextBackend(backends []*backend.BackendServer, log *slog
 
 	return selected
 }
+
+// WeightedRoundRobinAlgo implements nginx's smooth weighted round-robin:
+// each backend accrues its effective weight every pick, the highest-scoring
+// backend is chosen and then discounted by the total weight. This spreads
+// picks proportionally to weight without bursting all traffic to the
+// heaviest backend in a row.
+type WeightedRoundRobinAlgo struct {
+	mu             sync.Mutex
+	currentWeights map[*backend.BackendServer]int
+}
+
+func NewWeightedRoundRobinAlgo() *WeightedRoundRobinAlgo {
+	return &WeightedRoundRobinAlgo{currentWeights: make(map[*backend.BackendServer]int)}
+}
+
+func (a *WeightedRoundRobinAlgo) Name() string { return "weightedroundrobin" }
+
+func (a *WeightedRoundRobinAlgo) GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var selected *backend.BackendServer
+	totalWeight := 0
+
+	for _, b := range backends {
+		if excluded[b.URL.String()] || !b.Available() {
+			continue
+		}
+
+		weight := b.EffectiveWeight()
+		totalWeight += weight
+
+		a.currentWeights[b] += weight
+		if selected == nil || a.currentWeights[b] > a.currentWeights[selected] {
+			selected = b
+		}
+	}
+
+	if selected == nil {
+		log.Error("No healthy backends available")
+		return nil
+	}
+
+	a.currentWeights[selected] -= totalWeight
+	return selected
+}
+
+// WeightedLeastConnectionsAlgo selects the backend that minimizes
+// activeConns/weight, so heavier backends absorb proportionally more
+// concurrent connections before being considered busy.
+type WeightedLeastConnectionsAlgo struct{}
+
+func (a *WeightedLeastConnectionsAlgo) Name() string { return "weightedleastconnections" }
+
+func (a *WeightedLeastConnectionsAlgo) GetNextBackend(backends []*backend.BackendServer, excluded map[string]bool, log *slog.Logger) *backend.BackendServer {
+	var best *backend.BackendServer
+	bestLoad := math.MaxFloat64
+
+	for _, b := range backends {
+		if excluded[b.URL.String()] || !b.Available() {
+			continue
+		}
+
+		load := float64(b.GetActiveConns()) / float64(b.EffectiveWeight())
+		if best == nil || load < bestLoad {
+			best = b
+			bestLoad = load
+		}
+	}
+
+	if best == nil {
+		log.Error("No healthy backends available")
+		return nil
+	}
+
+	return best
+}
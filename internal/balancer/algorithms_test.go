@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"cloudru/internal/backend"
+	"io"
+	"log/slog"
+	"net/url"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func mustBackend(t *testing.T, rawURL string, weight int) *backend.BackendServer {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rawURL, err)
+	}
+	return &backend.BackendServer{URL: u, IsAlive: true, Weight: weight}
+}
+
+func TestWeightedRoundRobinDistributesProportionally(t *testing.T) {
+	a := mustBackend(t, "http://a", 5)
+	b := mustBackend(t, "http://b", 1)
+	c := mustBackend(t, "http://c", 1)
+	backends := []*backend.BackendServer{a, b, c}
+
+	algo := NewWeightedRoundRobinAlgo()
+	log := testLogger()
+
+	counts := map[*backend.BackendServer]int{}
+	const rounds = 700
+	for i := 0; i < rounds; i++ {
+		counts[algo.GetNextBackend(backends, nil, log)]++
+	}
+
+	total := a.Weight + b.Weight + c.Weight
+	wantA := float64(rounds) * float64(a.Weight) / float64(total)
+	gotA := float64(counts[a])
+
+	if diff := gotA - wantA; diff > wantA*0.05 || diff < -wantA*0.05 {
+		t.Fatalf("expected backend a to get roughly %.0f picks (weight 5/%d), got %d", wantA, total, counts[a])
+	}
+	if counts[b] == 0 || counts[c] == 0 {
+		t.Fatalf("expected lighter backends to still receive picks, got b=%d c=%d", counts[b], counts[c])
+	}
+}
+
+func TestWeightedRoundRobinSkipsUnavailableBackend(t *testing.T) {
+	a := mustBackend(t, "http://a", 1)
+	b := mustBackend(t, "http://b", 1)
+	b.IsAlive = false
+	backends := []*backend.BackendServer{a, b}
+
+	algo := NewWeightedRoundRobinAlgo()
+	log := testLogger()
+
+	for i := 0; i < 5; i++ {
+		if got := algo.GetNextBackend(backends, nil, log); got != a {
+			t.Fatalf("expected only available backend a to be picked, got %v", got)
+		}
+	}
+}
+
+func TestWeightedRoundRobinExcludesAttemptedBackend(t *testing.T) {
+	a := mustBackend(t, "http://a", 1)
+	b := mustBackend(t, "http://b", 1)
+	backends := []*backend.BackendServer{a, b}
+
+	algo := NewWeightedRoundRobinAlgo()
+	log := testLogger()
+
+	excluded := map[string]bool{a.URL.String(): true}
+	for i := 0; i < 5; i++ {
+		if got := algo.GetNextBackend(backends, excluded, log); got != b {
+			t.Fatalf("expected excluded backend a to be skipped, got %v", got)
+		}
+	}
+}
+
+func TestWeightedLeastConnectionsPrefersLowerLoadPerWeight(t *testing.T) {
+	heavy := mustBackend(t, "http://heavy", 4)
+	light := mustBackend(t, "http://light", 1)
+	heavy.IncrementConn()
+	heavy.IncrementConn()
+	heavy.IncrementConn() // 3/4 = 0.75 load
+	light.IncrementConn() // 1/1 = 1.0 load
+
+	algo := &WeightedLeastConnectionsAlgo{}
+	got := algo.GetNextBackend([]*backend.BackendServer{heavy, light}, nil, testLogger())
+
+	if got != heavy {
+		t.Fatalf("expected backend with lower conns/weight to be picked, got %v", got.URL)
+	}
+}
+
+func TestMaxConnsExcludesSaturatedBackend(t *testing.T) {
+	saturated := mustBackend(t, "http://saturated", 1)
+	saturated.InitSemaphore(1)
+	saturated.TryAcquire()
+
+	open := mustBackend(t, "http://open", 1)
+
+	algo := &LeastConnectionsAlgo{}
+	backends := []*backend.BackendServer{saturated, open}
+
+	for i := 0; i < 5; i++ {
+		if got := algo.GetNextBackend(backends, nil, testLogger()); got != open {
+			t.Fatalf("expected saturated backend to be skipped, got %v", got.URL)
+		}
+	}
+}
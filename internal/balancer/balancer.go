@@ -1,184 +1,511 @@
 package balancer
 
 import (
+	"bytes"
 	"cloudru/internal/backend"
+	"cloudru/internal/cache"
+	"cloudru/internal/config"
+	"cloudru/internal/router"
 	"context"
+	"io"
 	"log/slog"
-	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// tunables bundles the settings a config reload can change that the hot
+// path (ServeHTTP/dispatch/newRetryState) needs to read on every request.
+// ApplyConfig builds a new tunables value and publishes it with a single
+// atomic store, so a request always sees one fully-applied configuration
+// (never a mix of old and new fields) and never blocks on lb.mu to read it.
+type tunables struct {
+	algo          Algorithm
+	cache         *cache.Cache
+	cacheCfg      config.CacheConfig
+	outlierPolicy backend.OutlierPolicy
+	retryCfg      config.RetryConfig
+}
+
 type LoadBalancer struct {
-	backends  []*backend.BackendServer
-	current   uint64
-	mu        sync.Mutex
-	log       *slog.Logger
-	algorithm BalancingAlgorithm
-}
-
-func NewLoadBalancer(backends []string, log *slog.Logger, algorithmstr string) *LoadBalancer {
-	var algorithm BalancingAlgorithm
-	if algorithmstr == "random" {
-		algorithm = Random
-	} else if algorithmstr == "leastconnections" {
-		algorithm = LeastConnections
-	} else {
-		algorithm = RoundRobin
-	}
-	lb := &LoadBalancer{log: log, algorithm: algorithm}
-	lb.log.Info("Using load balancer with", "algorithm", algorithmstr)
-	for _, backendUrl := range backends {
-		parsedUrl, err := url.Parse(backendUrl)
-		if err != nil {
-			lb.log.Error("Failed to parse backend URL:", "error", err)
-		}
+	backends []*backend.BackendServer
+	mu       sync.Mutex
+	log      *slog.Logger
 
-		proxy := httputil.NewSingleHostReverseProxy(parsedUrl)
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			lb.mu.Lock()
-			for _, b := range lb.backends {
-				if b.URL == parsedUrl {
-					b.IsAlive = false
-					break
-				}
-			}
-			lb.mu.Unlock()
+	cfg atomic.Pointer[tunables]
 
-			lb.log.Error("Backend request failed",
-				"url", parsedUrl,
-				"error", err,
-			)
+	retriesTotal   int64
+	saturatedTotal int64
+}
 
-			if nextBackend := lb.GetNextBackend(); nextBackend != nil {
-				lb.log.Info("Retrying request with next backend", "url", nextBackend.URL)
-				nextBackend.ReverseProxy.ServeHTTP(w, r)
-				return
-			}
+func NewLoadBalancer(backends []config.BackendConfig, log *slog.Logger, algorithmstr string, cacheCfg config.CacheConfig, outlierCfg config.OutlierDetectionConfig, retryCfg config.RetryConfig) *LoadBalancer {
+	lb := &LoadBalancer{log: log}
 
-			lb.log.Error("All backends unavailable")
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte("All backends are down\n"))
-		}
+	t := &tunables{
+		algo:          NewAlgorithm(algorithmstr),
+		cacheCfg:      cacheCfg,
+		retryCfg:      retryCfg,
+		outlierPolicy: outlierPolicyFrom(outlierCfg),
+	}
+	if cacheCfg.Enabled {
+		t.cache = cache.New(cacheCfg.MaxBytes, cacheCfg.DefaultTTL)
+	}
+	lb.cfg.Store(t)
 
-		lb.backends = append(lb.backends, &backend.BackendServer{
-			URL:          parsedUrl,
-			ReverseProxy: proxy,
-			IsAlive:      true,
-		})
+	lb.log.Info("Using load balancer with", "algorithm", t.algo.Name())
+	for _, backendCfg := range backends {
+		lb.backends = append(lb.backends, lb.newBackend(backendCfg, retryCfg))
 	}
 	return lb
 }
 
-func (lb *LoadBalancer) GetNextBackend() *backend.BackendServer {
-	lb.mu.Lock()
-	defer lb.mu.Unlock()
+// tunables returns the currently published tunables snapshot.
+func (lb *LoadBalancer) tunables() *tunables {
+	return lb.cfg.Load()
+}
 
-	switch lb.algorithm {
-	case LeastConnections:
-		return lb.getLeastBusyBackend()
-	case Random:
-		return lb.getRandomBackend()
-	default:
-		return lb.getRoundRobinBackend()
+// newBackend builds a BackendServer for backendCfg, wired up with a
+// ReverseProxy whose ErrorHandler drives this LoadBalancer's retry logic
+// and whose connection slot count comes from backendCfg.MaxConns, falling
+// back to retryCfg.ConnsPerBackend.
+func (lb *LoadBalancer) newBackend(backendCfg config.BackendConfig, retryCfg config.RetryConfig) *backend.BackendServer {
+	parsedUrl, err := url.Parse(backendCfg.URL)
+	if err != nil {
+		lb.log.Error("Failed to parse backend URL:", "error", err)
 	}
+
+	proxy := httputil.NewSingleHostReverseProxy(parsedUrl)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if isRetryableStatus(resp.StatusCode) {
+			return errRetryableStatus
+		}
+		return nil
+	}
+	proxy.ErrorHandler = lb.errorHandlerFor(parsedUrl)
+
+	be := &backend.BackendServer{
+		URL:          parsedUrl,
+		ReverseProxy: proxy,
+		IsAlive:      true,
+		Weight:       backendCfg.Weight,
+		MaxConns:     backendCfg.MaxConns,
+		HealthPath:   backendCfg.HealthPath,
+	}
+	be.InitSemaphore(connCapacity(backendCfg, retryCfg))
+
+	return be
 }
 
-// least busy algorithm
-func (lb *LoadBalancer) getLeastBusyBackend() *backend.BackendServer {
-	var leastBusy *backend.BackendServer
-	minConns := int(^uint(0) >> 1)
+// connCapacity resolves the connection slot count for backendCfg: its own
+// MaxConns if set, otherwise the pool-wide retryCfg.ConnsPerBackend.
+func connCapacity(backendCfg config.BackendConfig, retryCfg config.RetryConfig) int {
+	if backendCfg.MaxConns > 0 {
+		return backendCfg.MaxConns
+	}
+	return retryCfg.ConnsPerBackend
+}
+
+// outlierPolicyFrom translates the YAML-facing OutlierDetectionConfig into
+// the policy type backend.BackendServer consumes.
+func outlierPolicyFrom(cfg config.OutlierDetectionConfig) backend.OutlierPolicy {
+	return backend.OutlierPolicy{
+		Consecutive5xx:   cfg.Consecutive5xx,
+		FailureRatio:     cfg.FailureRatio,
+		MinRequests:      cfg.MinRequests,
+		BaseEjectionTime: cfg.BaseEjectionTime,
+		MaxEjectionTime:  cfg.MaxEjectionTime,
+		LatencyBudget:    cfg.LatencyBudget,
+	}
+}
+
+// drainTimeout bounds how long ApplyConfig waits for a removed backend's
+// in-flight requests to finish before abandoning it anyway.
+const drainTimeout = 30 * time.Second
+
+// ApplyConfig reconciles the pool against cfg: backends present in both the
+// old and new lists are kept (with their weight/MaxConns/HealthPath
+// refreshed) so their circuit-breaker and connection state survives the
+// reload, new backends are added, and backends no longer present are
+// drained in the background and dropped. The algorithm, outlier policy and
+// retry policy are published as a new tunables snapshot (see applyTunables).
+func (lb *LoadBalancer) ApplyConfig(cfg config.Config) {
+	lb.mu.Lock()
 
-	for _, backend := range lb.backends {
-		if !backend.IsAlive {
+	existing := make(map[string]*backend.BackendServer, len(lb.backends))
+	for _, be := range lb.backends {
+		existing[be.URL.String()] = be
+	}
+
+	wanted := make(map[string]bool, len(cfg.Backends))
+	next := make([]*backend.BackendServer, 0, len(cfg.Backends))
+	for _, backendCfg := range cfg.Backends {
+		parsedUrl, err := url.Parse(backendCfg.URL)
+		if err != nil {
+			lb.log.Error("Failed to parse backend URL while applying config:", "error", err)
 			continue
 		}
-
-		conns := backend.GetActiveConns()
-		if conns < minConns {
-			leastBusy = backend
-			minConns = conns
+		wanted[parsedUrl.String()] = true
+
+		if be, ok := existing[parsedUrl.String()]; ok {
+			be.Weight = backendCfg.Weight
+			be.MaxConns = backendCfg.MaxConns
+			be.HealthPath = backendCfg.HealthPath
+			// Resize the connection-slot cap in place: InitSemaphore
+			// carries over slots already reserved by in-flight requests.
+			be.InitSemaphore(connCapacity(backendCfg, cfg.Retry))
+			next = append(next, be)
+			continue
 		}
+
+		next = append(next, lb.newBackend(backendCfg, cfg.Retry))
 	}
 
-	if leastBusy == nil {
-		lb.log.Error("No healthy backends available")
-		return nil
+	var removed []*backend.BackendServer
+	for urlStr, be := range existing {
+		if !wanted[urlStr] {
+			removed = append(removed, be)
+		}
 	}
 
-	return leastBusy
-}
+	lb.backends = next
 
-// random algorithm
-func (lb *LoadBalancer) getRandomBackend() *backend.BackendServer {
-	var available []*backend.BackendServer
-	for _, backend := range lb.backends {
-		if backend.IsAlive {
-			available = append(available, backend)
-		}
+	lb.mu.Unlock()
+
+	algoName := lb.applyTunables(cfg)
+
+	lb.log.Info("Applied updated configuration", "algorithm", algoName, "backends", len(next))
+
+	for _, be := range removed {
+		go lb.drainBackend(be)
 	}
+}
 
-	if len(available) == 0 {
-		lb.log.Error("No healthy backends available")
-		return nil
+// applyTunables builds a new tunables value from cfg and publishes it with a
+// single atomic store. It returns the new algorithm's name for logging.
+//
+// The cache instance is reconciled against cfg.Cache: toggling Enabled off
+// drops it, toggling it on (or changing MaxBytes/DefaultTTL, which
+// cache.Cache has no setter for) builds a fresh one, and anything else
+// about CacheConfig (RespectHeaders, Methods) applies to the existing
+// instance without disturbing what's cached.
+func (lb *LoadBalancer) applyTunables(cfg config.Config) string {
+	prev := lb.tunables()
+	algo := NewAlgorithm(cfg.Algorithm)
+
+	c := prev.cache
+	switch {
+	case !cfg.Cache.Enabled:
+		c = nil
+	case c == nil || prev.cacheCfg.MaxBytes != cfg.Cache.MaxBytes || prev.cacheCfg.DefaultTTL != cfg.Cache.DefaultTTL:
+		c = cache.New(cfg.Cache.MaxBytes, cfg.Cache.DefaultTTL)
 	}
 
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return available[rng.Intn(len(available))]
+	lb.cfg.Store(&tunables{
+		algo:          algo,
+		cache:         c,
+		cacheCfg:      cfg.Cache,
+		outlierPolicy: outlierPolicyFrom(cfg.OutlierDetection),
+		retryCfg:      cfg.Retry,
+	})
+
+	return algo.Name()
 }
 
-// round robin algorithm
-func (lb *LoadBalancer) getRoundRobinBackend() *backend.BackendServer {
-	if len(lb.backends) == 0 {
-		lb.log.Error("No backends configured")
-		return nil
+// drainBackend stops routing new requests to be (the caller has already
+// removed it from lb.backends) and returns once its in-flight requests
+// finish or drainTimeout elapses, whichever comes first.
+func (lb *LoadBalancer) drainBackend(be *backend.BackendServer) {
+	be.IsAlive = false
+
+	deadline := time.Now().Add(drainTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for be.GetActiveConns() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
 	}
 
-	start := lb.current
-	for {
-		idx := lb.current % uint64(len(lb.backends))
-		backend := lb.backends[idx]
+	if conns := be.GetActiveConns(); conns > 0 {
+		lb.log.Info("Drain timeout reached for removed backend, dropping anyway", "url", be.URL, "active_conns", conns)
+		return
+	}
+	lb.log.Info("Drained removed backend", "url", be.URL)
+}
+
+// errorHandlerFor builds the ReverseProxy.ErrorHandler for the backend at
+// backendURL. It records the failed outcome, then retries against another
+// backend if the request's retry budget and method/body eligibility allow
+// it, falling back to a final error response otherwise.
+func (lb *LoadBalancer) errorHandlerFor(backendURL *url.URL) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if marker := attemptMarkerFrom(r.Context()); marker != nil {
+			marker.failed = true
+		}
 
-		if backend.IsAlive {
-			lb.current++
-			return backend
+		lb.mu.Lock()
+		var failed *backend.BackendServer
+		for _, b := range lb.backends {
+			if b.URL == backendURL {
+				failed = b
+				break
+			}
 		}
+		lb.mu.Unlock()
 
-		lb.current++
-		if lb.current-start >= uint64(len(lb.backends)) {
-			break
+		if failed != nil {
+			failed.RecordOutcome(false, 0, lb.tunables().outlierPolicy)
+		}
+
+		log := lb.requestLogger(r)
+		log.Error("Backend request failed",
+			"url", backendURL,
+			"error", err,
+		)
+
+		st := retryStateFrom(r.Context())
+		if st == nil || !st.canRetry() {
+			log.Error("All backends unavailable or retry budget exhausted")
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("All backends are down\n"))
+			return
 		}
+
+		st.retriesLeft--
+		atomic.AddInt64(&lb.retriesTotal, 1)
+		lb.dispatch(w, r, log, st)
 	}
+}
+
+// GetNextBackend delegates to the configured Algorithm, handing it a snapshot
+// of every backend in the pool along with the set already excluded for this
+// request (e.g. a prior failed attempt); the algorithm itself skips backends
+// that are excluded, unhealthy, saturated or ejected. log receives any
+// "no healthy backend" diagnostics the algorithm emits.
+func (lb *LoadBalancer) GetNextBackend(log *slog.Logger, excluded map[string]bool) *backend.BackendServer {
+	lb.mu.Lock()
+	candidates := make([]*backend.BackendServer, len(lb.backends))
+	copy(candidates, lb.backends)
+	lb.mu.Unlock()
 
-	lb.log.Error("No healthy backends available")
-	return nil
+	return lb.tunables().algo.GetNextBackend(candidates, excluded, log)
+}
+
+// requestLogger returns lb.log annotated with the request id injected by
+// router.Router, if any, so every log line for a request can be correlated.
+func (lb *LoadBalancer) requestLogger(r *http.Request) *slog.Logger {
+	if id := router.RequestIDFromContext(r.Context()); id != "" {
+		return lb.log.With("request_id", id)
+	}
+	return lb.log
 }
 
 func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	backend := lb.GetNextBackend()
-	if backend == nil {
-		lb.log.Error("No available backends")
+	log := lb.requestLogger(r)
+
+	if retryStateFrom(r.Context()) == nil {
+		r = r.WithContext(withRetryState(r.Context(), lb.newRetryState(r)))
+	}
+
+	if lb.tunables().cache != nil && lb.cacheableMethod(r.Method) {
+		lb.serveCached(w, r, log)
+		return
+	}
+
+	lb.serveUncached(w, r, log)
+}
+
+// serveUncached picks a backend and proxies the request to it directly,
+// with no cache involvement.
+func (lb *LoadBalancer) serveUncached(w http.ResponseWriter, r *http.Request, log *slog.Logger) {
+	st := retryStateFrom(r.Context())
+	if st == nil {
+		st = lb.newRetryState(r)
+	}
+	lb.dispatch(w, r, log, st)
+}
+
+// dispatch picks one backend not yet in st.excluded and proxies the
+// request to it. On failure, the backend's ErrorHandler (set up in
+// newBackend) consults st itself and recurses into dispatch against
+// the next backend, so a single top-level call handles the whole retry
+// chain for a request.
+func (lb *LoadBalancer) dispatch(w http.ResponseWriter, r *http.Request, log *slog.Logger, st *retryState) {
+	be := lb.GetNextBackend(log, st.excluded)
+	if be == nil {
+		log.Error("No available backends")
 		http.Error(w, "All backends are down", http.StatusServiceUnavailable)
 		return
 	}
+	st.excluded[be.URL.String()] = true
 
-	backend.IncrementConn()
-	defer backend.DecrementConn()
+	if !be.TryAcquire() {
+		atomic.AddInt64(&lb.saturatedTotal, 1)
+		log.Error("Backend saturated", "url", be.URL)
+		lb.retryOrFail(w, r, log, st)
+		return
+	}
+
+	if !be.BeginAttempt() {
+		be.Release()
+		log.Error("Backend rejected by circuit breaker", "url", be.URL)
+		lb.retryOrFail(w, r, log, st)
+		return
+	}
+	defer be.Release()
+
+	be.IncrementConn()
+	defer be.DecrementConn()
+
+	if st.body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(st.body))
+	}
 
-	lb.log.Info("Forwarding request",
-		"url", backend.URL,
-		"algorithm", lb.algorithm.String(),
-		"active_conns", backend.GetActiveConns(),
+	log.Info("Forwarding request",
+		"url", be.URL,
+		"algorithm", lb.tunables().algo.Name(),
+		"active_conns", be.GetActiveConns(),
 	)
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	backend.ReverseProxy.ServeHTTP(w, r.WithContext(ctx))
+	marker := &attemptMarker{}
+	ctx = withAttemptMarker(ctx, marker)
+
+	sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	start := time.Now()
+
+	be.ReverseProxy.ServeHTTP(sw, r.WithContext(ctx))
+
+	if marker.failed {
+		// ErrorHandler already recorded the outcome and either retried or
+		// wrote the final response.
+		return
+	}
+
+	be.RecordOutcome(sw.statusCode < http.StatusInternalServerError, time.Since(start), lb.tunables().outlierPolicy)
+}
+
+// retryOrFail consumes one unit of retry budget and dispatches to another
+// backend, or writes the final error response once the budget (or method/
+// body eligibility) is exhausted. It handles failures that happen before
+// the backend's ReverseProxy is even reached, e.g. a circuit-open or
+// saturated backend, which never go through ErrorHandler.
+func (lb *LoadBalancer) retryOrFail(w http.ResponseWriter, r *http.Request, log *slog.Logger, st *retryState) {
+	if st.canRetry() {
+		st.retriesLeft--
+		atomic.AddInt64(&lb.retriesTotal, 1)
+		lb.dispatch(w, r, log, st)
+		return
+	}
+
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// statusWriter wraps an http.ResponseWriter to observe the status code a
+// handler wrote, for passive health tracking.
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+	wroteHdr   bool
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if !sw.wroteHdr {
+		sw.statusCode = statusCode
+		sw.wroteHdr = true
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHdr {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// serveCached consults the response cache before dispatching to a backend.
+// On a hit the stored response is replayed directly; on a miss the request
+// is proxied normally and, if the response is cacheable, the result is
+// stored for subsequent requests. Once a response for the path has recorded
+// its Vary header, concurrent misses for the same key are coalesced so only
+// one of them reaches a backend; before that, each miss is fetched on its own.
+func (lb *LoadBalancer) serveCached(w http.ResponseWriter, r *http.Request, log *slog.Logger) {
+	// Snapshot tunables once so the cache instance and its RespectHeaders
+	// setting can't change out from under a single request even if a
+	// reload is applied concurrently.
+	t := lb.tunables()
+	c := t.cache
+
+	// Until a response for this path has told us what it varies on, Key
+	// folds in no header values, so two concurrent requests that differ
+	// only in a header the eventual Vary will name would compute the same
+	// key. Coalescing those through cache.Do would hand one of them the
+	// other's variant, so only share the fetch once the path's Vary is known.
+	knownVary := c.KnownVary(r)
+
+	key := c.Key(r)
+	if entry, ok := c.Get(key); ok {
+		cache.WriteTo(w, entry)
+		return
+	}
+
+	fetch := func() (*cache.Entry, error) {
+		rec := cache.NewRecorder()
+		lb.serveUncached(rec, r, log)
+		entry := rec.Entry()
+
+		c.RecordVary(r, entry.Header)
+		// Recompute the key now that Vary is known: the entry must be
+		// stored under the same key a later, header-aware lookup will use,
+		// not the pre-Vary key this call started with.
+		storeKey := c.Key(r)
+
+		if cache.CacheableStatus(entry.StatusCode) {
+			ttl, ok := cache.TTLFromHeaders(entry.Header)
+			if ok && t.cacheCfg.RespectHeaders {
+				entry.ExpiresAt = time.Now().Add(ttl)
+			}
+			c.Set(storeKey, entry)
+		}
+
+		return entry, nil
+	}
+
+	var entry *cache.Entry
+	var err error
+	if knownVary {
+		entry, err = c.Do(key, fetch)
+	} else {
+		entry, err = fetch()
+	}
+	if err != nil {
+		log.Error("cache fetch failed", "error", err)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	cache.WriteTo(w, entry)
+}
+
+// cacheableMethod reports whether method is eligible for the cache, per the
+// configured cache.methods list (defaulting to GET/HEAD when unset).
+func (lb *LoadBalancer) cacheableMethod(method string) bool {
+	cacheCfg := lb.tunables().cacheCfg
+	if len(cacheCfg.Methods) == 0 {
+		return cache.SafeMethod(method)
+	}
+	for _, m := range cacheCfg.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
 }
 
 func (lb *LoadBalancer) CheckBackendHealth(backend *backend.BackendServer) bool {
@@ -186,10 +513,15 @@ func (lb *LoadBalancer) CheckBackendHealth(backend *backend.BackendServer) bool
 		Timeout: 5 * time.Second,
 	}
 
-	resp, err := client.Get(backend.URL.String())
+	healthURL := backend.URL.String()
+	if backend.HealthPath != "" {
+		healthURL = backend.URL.ResolveReference(&url.URL{Path: backend.HealthPath}).String()
+	}
+
+	resp, err := client.Get(healthURL)
 	if err != nil {
 		lb.log.Debug("Health check failed",
-			"url", backend.URL,
+			"url", healthURL,
 			"error", err,
 		)
 		return false
@@ -0,0 +1,368 @@
+package balancer
+
+import (
+	"cloudru/internal/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func testBalancerLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func backendConfigs(t *testing.T, servers ...*httptest.Server) []config.BackendConfig {
+	t.Helper()
+	cfgs := make([]config.BackendConfig, len(servers))
+	for i, s := range servers {
+		cfgs[i] = config.BackendConfig{URL: s.URL, Weight: 1}
+	}
+	return cfgs
+}
+
+func TestServeHTTPRetriesOnRetryableStatus(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	var goodHits int64
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&goodHits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, bad, good), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected retry to reach the healthy backend, got status %d", rec.Code)
+	}
+	if atomic.LoadInt64(&goodHits) != 1 {
+		t.Fatalf("expected exactly one request to the healthy backend, got %d", goodHits)
+	}
+}
+
+func TestServeHTTPExhaustsRetryBudget(t *testing.T) {
+	var hits int64
+	badHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	bad1 := httptest.NewServer(badHandler)
+	defer bad1.Close()
+	bad2 := httptest.NewServer(badHandler)
+	defer bad2.Close()
+	bad3 := httptest.NewServer(badHandler)
+	defer bad3.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, bad1, bad2, bad3), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected final 503 once retries are exhausted, got %d", rec.Code)
+	}
+	if atomic.LoadInt64(&hits) != 3 {
+		t.Fatalf("expected the first attempt plus both retries to reach a backend, got %d", hits)
+	}
+	if atomic.LoadInt64(&lb.retriesTotal) != 2 {
+		t.Fatalf("expected retries_total to count both retries, got %d", lb.retriesTotal)
+	}
+}
+
+func TestServeHTTPDisablesRetryForOversizedNonIdempotentBody(t *testing.T) {
+	var hits int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, bad, good), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 2, MaxRetryBodyBytes: 4})
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("this body is way over the cap"))
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected retry to be disabled for an oversized POST body, got %d", rec.Code)
+	}
+	if atomic.LoadInt64(&hits) != 1 {
+		t.Fatalf("expected only the first attempt, got %d hits", hits)
+	}
+}
+
+func TestServeHTTPTreatsSaturatedBackendAsUnavailable(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, good), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 1, ConnsPerBackend: 1})
+
+	lb.backends[0].TryAcquire() // occupy the only slot, as a concurrent in-flight request would
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected saturated backend to be treated as unavailable rather than blocking, got %d", rec.Code)
+	}
+}
+
+func TestStatusHandlerReportsRetryCounters(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, bad), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 1})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	lb.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	lb.StatusHandler()(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if !strings.Contains(rec.Body.String(), `"retries_total":1`) {
+		t.Fatalf("expected status response to report retries_total, got %s", rec.Body.String())
+	}
+}
+
+func TestApplyConfigAddsRemovesAndPreservesBackends(t *testing.T) {
+	kept := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer kept.Close()
+	removed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer removed.Close()
+	added := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer added.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, kept, removed), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{})
+	keptBackend := lb.backends[0]
+	keptBackend.IncrementConn() // simulate an in-flight request surviving the reload
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, kept, added),
+		Algorithm: "leastconnections",
+	})
+
+	if lb.tunables().algo.Name() != "leastconnections" {
+		t.Fatalf("expected algorithm to switch to leastconnections, got %s", lb.tunables().algo.Name())
+	}
+	if len(lb.backends) != 2 {
+		t.Fatalf("expected exactly 2 backends after reload, got %d", len(lb.backends))
+	}
+
+	var sawKept, sawAdded bool
+	for _, be := range lb.backends {
+		switch be.URL.String() {
+		case kept.URL:
+			sawKept = be == keptBackend
+		case added.URL:
+			sawAdded = true
+		}
+	}
+	if !sawKept {
+		t.Fatalf("expected the kept backend's state to be preserved across reload")
+	}
+	if !sawAdded {
+		t.Fatalf("expected the newly configured backend to be present")
+	}
+	if keptBackend.GetActiveConns() != 1 {
+		t.Fatalf("expected kept backend's in-flight connection count to survive reload, got %d", keptBackend.GetActiveConns())
+	}
+}
+
+func TestApplyConfigResizesConnectionCapOfKeptBackend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer srv.Close()
+
+	lb := NewLoadBalancer([]config.BackendConfig{{URL: srv.URL, Weight: 1, MaxConns: 1}}, testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{})
+	be := lb.backends[0]
+
+	if !be.TryAcquire() {
+		t.Fatalf("expected the single slot to be available before reload")
+	}
+	if be.TryAcquire() {
+		t.Fatalf("expected MaxConns: 1 to reject a second concurrent slot")
+	}
+
+	lb.ApplyConfig(config.Config{
+		Backends:  []config.BackendConfig{{URL: srv.URL, Weight: 1, MaxConns: 5}},
+		Algorithm: "roundrobin",
+	})
+
+	if lb.backends[0] != be {
+		t.Fatalf("expected the backend pointer to be preserved across reload")
+	}
+
+	acquired := 1 // the slot reserved before the reload
+	for i := 0; i < 4; i++ {
+		if !be.TryAcquire() {
+			t.Fatalf("expected capacity to have grown to 5, only acquired %d more slots", i)
+		}
+		acquired++
+	}
+	if be.TryAcquire() {
+		t.Fatalf("expected the resized cap of 5 to be enforced, acquired a 6th slot")
+	}
+	if acquired != 5 {
+		t.Fatalf("expected exactly 5 slots to be acquirable after resize, got %d", acquired)
+	}
+}
+
+// TestServeHTTPConcurrentWithApplyConfigIsRaceFree reproduces a reload
+// applied while requests are in flight: ServeHTTP reads the tunables
+// ApplyConfig publishes (algorithm, retry/cache config, outlier policy) on
+// every request, so this must be race-free under `go test -race`.
+func TestServeHTTPConcurrentWithApplyConfigIsRaceFree(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, good), testBalancerLogger(), "roundrobin", config.CacheConfig{}, config.OutlierDetectionConfig{}, config.RetryConfig{MaxRetries: 1})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					req := httptest.NewRequest(http.MethodGet, "/", nil)
+					lb.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		lb.ApplyConfig(config.Config{
+			Backends:  backendConfigs(t, good),
+			Algorithm: "leastconnections",
+			Retry:     config.RetryConfig{MaxRetries: 1},
+		})
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestApplyConfigEnablesCacheThatWasOffAtStartup(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, srv), testBalancerLogger(), "roundrobin", config.CacheConfig{Enabled: false}, config.OutlierDetectionConfig{}, config.RetryConfig{})
+
+	if lb.tunables().cache != nil {
+		t.Fatalf("expected cache to be off at startup")
+	}
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, srv),
+		Algorithm: "roundrobin",
+		Cache:     config.CacheConfig{Enabled: true, MaxBytes: 1024},
+	})
+
+	if lb.tunables().cache == nil {
+		t.Fatalf("expected cache to turn on after ApplyConfig enables it")
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		lb.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if atomic.LoadInt64(&hits) != 1 {
+		t.Fatalf("expected the second request to be served from the newly enabled cache, got %d backend hits", hits)
+	}
+}
+
+func TestApplyConfigDisablesCacheThatWasOnAtStartup(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, srv), testBalancerLogger(), "roundrobin", config.CacheConfig{Enabled: true, MaxBytes: 1024}, config.OutlierDetectionConfig{}, config.RetryConfig{})
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, srv),
+		Algorithm: "roundrobin",
+		Cache:     config.CacheConfig{Enabled: false},
+	})
+
+	if lb.tunables().cache != nil {
+		t.Fatalf("expected cache to turn off after ApplyConfig disables it")
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		lb.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if atomic.LoadInt64(&hits) != 2 {
+		t.Fatalf("expected every request to reach the backend once caching is disabled, got %d hits", hits)
+	}
+}
+
+func TestApplyConfigRebuildsCacheWhenBoundsChange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer srv.Close()
+
+	lb := NewLoadBalancer(backendConfigs(t, srv), testBalancerLogger(), "roundrobin", config.CacheConfig{Enabled: true, MaxBytes: 1024}, config.OutlierDetectionConfig{}, config.RetryConfig{})
+	original := lb.tunables().cache
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, srv),
+		Algorithm: "roundrobin",
+		Cache:     config.CacheConfig{Enabled: true, MaxBytes: 2048},
+	})
+
+	if lb.tunables().cache == original {
+		t.Fatalf("expected a changed MaxBytes to rebuild the cache instance")
+	}
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, srv),
+		Algorithm: "roundrobin",
+		Cache:     config.CacheConfig{Enabled: true, MaxBytes: 2048},
+	})
+	unchanged := lb.tunables().cache
+
+	lb.ApplyConfig(config.Config{
+		Backends:  backendConfigs(t, srv),
+		Algorithm: "roundrobin",
+		Cache:     config.CacheConfig{Enabled: true, MaxBytes: 2048, RespectHeaders: true},
+	})
+	if lb.tunables().cache != unchanged {
+		t.Fatalf("expected the cache instance to survive a reload that doesn't touch MaxBytes/DefaultTTL")
+	}
+}
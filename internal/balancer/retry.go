@@ -0,0 +1,127 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// errRetryableStatus is returned from a backend's ModifyResponse hook to
+// route a 502/503/504 response through ErrorHandler instead of forwarding
+// it to the client, so it can be retried like a transport error.
+var errRetryableStatus = errors.New("retryable upstream status")
+
+// isRetryableStatus reports whether status should be treated as a failed
+// attempt eligible for retry against another backend.
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryState tracks, for a single client request, which backends have
+// already been tried and how much retry budget remains. It is attached to
+// the request context by ServeHTTP and threaded through every dispatch
+// attempt, including the ones ErrorHandler triggers on failure.
+type retryState struct {
+	excluded    map[string]bool
+	retriesLeft int
+
+	// body holds the full request body when it was small enough to buffer
+	// for replay; nil means there was no body or it exceeded the cap.
+	body []byte
+	// methodEligible reports whether a retry is allowed at all for this
+	// request: idempotent methods always qualify, others only if body
+	// buffering succeeded.
+	methodEligible bool
+}
+
+// canRetry reports whether another backend may be tried.
+func (st *retryState) canRetry() bool {
+	return st.retriesLeft > 0 && st.methodEligible
+}
+
+type retryStateKey struct{}
+
+func withRetryState(ctx context.Context, st *retryState) context.Context {
+	return context.WithValue(ctx, retryStateKey{}, st)
+}
+
+func retryStateFrom(ctx context.Context) *retryState {
+	st, _ := ctx.Value(retryStateKey{}).(*retryState)
+	return st
+}
+
+// attemptMarker lets a backend's ErrorHandler tell the dispatch call that
+// invoked it whether the attempt failed, so the caller doesn't also record
+// an outcome or write a response for an attempt ErrorHandler already
+// resolved (by retrying or by writing the final error itself).
+type attemptMarker struct {
+	failed bool
+}
+
+type attemptMarkerKey struct{}
+
+func withAttemptMarker(ctx context.Context, m *attemptMarker) context.Context {
+	return context.WithValue(ctx, attemptMarkerKey{}, m)
+}
+
+func attemptMarkerFrom(ctx context.Context) *attemptMarker {
+	m, _ := ctx.Value(attemptMarkerKey{}).(*attemptMarker)
+	return m
+}
+
+// isIdempotentMethod reports whether method is safe to retry against a
+// different backend without buffering its body.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// newRetryState builds the retry bookkeeping for an incoming request,
+// buffering its body (up to the configured MaxRetryBodyBytes) so a retry can
+// replay it.
+func (lb *LoadBalancer) newRetryState(r *http.Request) *retryState {
+	retryCfg := lb.tunables().retryCfg
+	body, bodyBuffered := bufferRetryBody(r, retryCfg.MaxRetryBodyBytes)
+	return &retryState{
+		excluded:       make(map[string]bool),
+		retriesLeft:    retryCfg.MaxRetries,
+		body:           body,
+		methodEligible: isIdempotentMethod(r.Method) || bodyBuffered,
+	}
+}
+
+// bufferRetryBody reads up to maxBytes+1 of r.Body to find out whether the
+// whole body fits the cap. When it does, it returns the buffered bytes and
+// reconstructs r.Body so the current attempt still sees the full body.
+// When it doesn't, the body is spliced back together unread (the current
+// attempt is unaffected) but no bytes are returned, since only a truncated
+// prefix was captured.
+func bufferRetryBody(r *http.Request, maxBytes int64) (body []byte, buffered bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, false
+	}
+
+	if int64(len(buf)) > maxBytes {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), r.Body))
+		return nil, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	return buf, true
+}
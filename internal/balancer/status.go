@@ -0,0 +1,58 @@
+package balancer
+
+import (
+	"cloudru/internal/backend"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+type backendStatus struct {
+	URL          string `json:"url"`
+	Alive        bool   `json:"alive"`
+	CircuitState string `json:"circuit_state"`
+	ActiveConns  int    `json:"active_conns"`
+	RecentErrors int    `json:"recent_errors"`
+}
+
+// statusResponse is the payload served by StatusHandler.
+type statusResponse struct {
+	Backends       []backendStatus `json:"backends"`
+	RetriesTotal   int64           `json:"retries_total"`
+	SaturatedTotal int64           `json:"saturated_total"`
+}
+
+// StatusHandler reports per-backend health (liveness, circuit-breaker
+// state, active connections and recent error counts from the outlier
+// detection window) along with the pool's cumulative retry and
+// saturation-rejection counters.
+func (lb *LoadBalancer) StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lb.mu.Lock()
+		backends := make([]*backend.BackendServer, len(lb.backends))
+		copy(backends, lb.backends)
+		lb.mu.Unlock()
+
+		statuses := make([]backendStatus, 0, len(backends))
+		for _, be := range backends {
+			statuses = append(statuses, backendStatus{
+				URL:          be.URL.String(),
+				Alive:        be.IsAlive,
+				CircuitState: be.CircuitState().String(),
+				ActiveConns:  be.GetActiveConns(),
+				RecentErrors: be.RecentErrors(),
+			})
+		}
+
+		resp := statusResponse{
+			Backends:       statuses,
+			RetriesTotal:   atomic.LoadInt64(&lb.retriesTotal),
+			SaturatedTotal: atomic.LoadInt64(&lb.saturatedTotal),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			lb.log.Error("failed to encode status response", "error", err)
+		}
+	}
+}
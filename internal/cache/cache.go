@@ -0,0 +1,148 @@
+// Package cache implements an in-process, byte-bounded LRU cache of HTTP
+// responses with per-entry TTL and single-flight coalescing of concurrent
+// misses for the same key.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Entry is a stored response, ready to be replayed verbatim to future callers.
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	ExpiresAt  time.Time
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for k, values := range e.Header {
+		size += int64(len(k))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
+
+type node struct {
+	key   string
+	entry *Entry
+}
+
+// Cache is an LRU of Entry values bounded by total byte size rather than
+// entry count, so a handful of large responses can't starve many small ones.
+type Cache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	usedBytes  int64
+	defaultTTL time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+	varyIndex  map[string][]string
+
+	group singleflight.Group
+}
+
+// New returns a Cache capped at maxBytes of stored response data. Entries
+// without an explicit expiry fall back to defaultTTL (zero means "forever").
+func New(maxBytes int64, defaultTTL time.Duration) *Cache {
+	return &Cache{
+		maxBytes:   maxBytes,
+		defaultTTL: defaultTTL,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		varyIndex:  make(map[string][]string),
+	}
+}
+
+// Get returns the cached entry for key, if present and not expired. A hit
+// moves the entry to the front of the LRU order.
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	n := elem.Value.(*node)
+	if n.entry.expired(time.Now()) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	return n.entry, true
+}
+
+// Set stores entry under key, evicting least-recently-used entries until the
+// cache fits within maxBytes. If entry.ExpiresAt is zero, the cache's
+// defaultTTL is applied. Entries larger than maxBytes are not stored.
+func (c *Cache) Set(key string, entry *Entry) {
+	if entry.ExpiresAt.IsZero() && c.defaultTTL > 0 {
+		entry.ExpiresAt = time.Now().Add(c.defaultTTL)
+	}
+
+	size := entry.size()
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+
+	elem := c.ll.PushFront(&node{key: key, entry: entry})
+	c.items[key] = elem
+	c.usedBytes += size
+
+	for c.maxBytes > 0 && c.usedBytes > c.maxBytes {
+		back := c.ll.Back()
+		if back == nil {
+			break
+		}
+		c.removeElement(back)
+	}
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	n := elem.Value.(*node)
+	c.ll.Remove(elem)
+	delete(c.items, n.key)
+	c.usedBytes -= n.entry.size()
+}
+
+// Len reports the number of entries currently cached.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Do coalesces concurrent calls sharing the same key: the first caller runs
+// fn, and every caller that arrives while it is in flight blocks on and
+// receives its result instead of triggering its own upstream request.
+func (c *Cache) Do(key string, fn func() (*Entry, error)) (*Entry, error) {
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return fn()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Entry), nil
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(10, 0)
+
+	c.Set("a", &Entry{Body: []byte("aaaaa")}) // 5 bytes
+	c.Set("b", &Entry{Body: []byte("bbbbb")}) // 5 bytes, cache now full
+
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to still be cached")
+	}
+
+	// a is now most-recently-used; inserting c should evict b, not a.
+	c.Set("c", &Entry{Body: []byte("ccccc")})
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected b to have been evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Fatalf("expected c to be cached")
+	}
+}
+
+func TestCacheEntryExpiresAfterTTL(t *testing.T) {
+	c := New(1024, 0)
+
+	c.Set("k", &Entry{Body: []byte("v"), ExpiresAt: time.Now().Add(-time.Second)})
+
+	if _, ok := c.Get("k"); ok {
+		t.Fatalf("expected expired entry to be evicted on read")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected expired entry to be removed, len=%d", c.Len())
+	}
+}
+
+func TestCacheDefaultTTLAppliesWhenEntryHasNoExpiry(t *testing.T) {
+	c := New(1024, time.Minute)
+
+	c.Set("k", &Entry{Body: []byte("v")})
+
+	entry, ok := c.Get("k")
+	if !ok {
+		t.Fatalf("expected entry to be cached")
+	}
+	if entry.ExpiresAt.IsZero() {
+		t.Fatalf("expected default TTL to populate ExpiresAt")
+	}
+}
+
+func TestCacheDoCoalescesConcurrentMisses(t *testing.T) {
+	c := New(1024, 0)
+
+	var calls int32
+	const n = 20
+
+	results := make(chan *Entry, n)
+	start := make(chan struct{})
+
+	for i := 0; i < n; i++ {
+		go func() {
+			<-start
+			entry, err := c.Do("k", func() (*Entry, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(10 * time.Millisecond)
+				return &Entry{Body: []byte("v")}, nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- entry
+		}()
+	}
+	close(start)
+
+	for i := 0; i < n; i++ {
+		<-results
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected exactly 1 upstream call, got %d", got)
+	}
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheableStatus reports whether status is one of the response codes this
+// cache is willing to store (a conservative subset of RFC 7231's
+// heuristically-cacheable codes).
+func CacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusGone:
+		return true
+	default:
+		return false
+	}
+}
+
+// SafeMethod reports whether method is safe to serve from cache (GET/HEAD).
+func SafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// Key derives the cache key for r: its method, host and request URI, plus
+// the values of any headers a previously recorded response (see RecordVary)
+// for the same method, host and URI named in its Vary header.
+func (c *Cache) Key(r *http.Request) string {
+	base := r.Method + " " + r.Host + r.URL.RequestURI()
+
+	c.mu.Lock()
+	names := c.varyIndex[base]
+	c.mu.Unlock()
+
+	if len(names) == 0 {
+		return base
+	}
+
+	key := base
+	for _, name := range names {
+		key += "|" + name + "=" + r.Header.Get(name)
+	}
+	return key
+}
+
+// RecordVary remembers the Vary header of a freshly-fetched response so that
+// later calls to Key fold the named request headers into the cache key. It
+// records even a blank Vary header, so KnownVary can tell "this path doesn't
+// vary" apart from "we haven't fetched this path yet".
+func (c *Cache) RecordVary(r *http.Request, header http.Header) {
+	var names []string
+	if vary := header.Get("Vary"); vary != "" {
+		for _, name := range strings.Split(vary, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	base := r.Method + " " + r.Host + r.URL.RequestURI()
+	c.mu.Lock()
+	c.varyIndex[base] = names
+	c.mu.Unlock()
+}
+
+// KnownVary reports whether a prior response for r's path has already told
+// us which headers (if any) it varies on. Callers must not treat Key's
+// output as safe to share across concurrently-handled requests until this
+// returns true: before the first response is seen, two requests that differ
+// only in a header the eventual Vary will name both compute the same key.
+func (c *Cache) KnownVary(r *http.Request) bool {
+	base := r.Method + " " + r.Host + r.URL.RequestURI()
+	c.mu.Lock()
+	_, known := c.varyIndex[base]
+	c.mu.Unlock()
+	return known
+}
+
+// TTLFromHeaders derives a cache TTL from the Cache-Control max-age or
+// Expires response headers. It returns ok=false when the response explicitly
+// opts out of caching (no-store/no-cache) or carries no freshness
+// information, in which case the caller should fall back to its own default.
+func TTLFromHeaders(header http.Header) (ttl time.Duration, ok bool) {
+	if cc := header.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			switch {
+			case directive == "no-store" || directive == "no-cache":
+				return 0, false
+			case strings.HasPrefix(directive, "max-age="):
+				secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+				if err == nil && secs >= 0 {
+					return time.Duration(secs) * time.Second, true
+				}
+			}
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d, true
+			}
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// Recorder captures a response in memory so it can be both replayed to the
+// client and, if cacheable, stored as an Entry.
+type Recorder struct {
+	header     http.Header
+	body       []byte
+	statusCode int
+	wroteHdr   bool
+}
+
+// NewRecorder returns an empty Recorder ready to be passed as an
+// http.ResponseWriter.
+func NewRecorder() *Recorder {
+	return &Recorder{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (r *Recorder) Header() http.Header { return r.header }
+
+func (r *Recorder) WriteHeader(statusCode int) {
+	if r.wroteHdr {
+		return
+	}
+	r.statusCode = statusCode
+	r.wroteHdr = true
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if !r.wroteHdr {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body = append(r.body, b...)
+	return len(b), nil
+}
+
+// Entry snapshots the recorded response as a cache Entry.
+func (r *Recorder) Entry() *Entry {
+	return &Entry{
+		StatusCode: r.statusCode,
+		Header:     r.header.Clone(),
+		Body:       append([]byte(nil), r.body...),
+	}
+}
+
+// WriteTo replays entry to w exactly as it was originally received.
+func WriteTo(w http.ResponseWriter, entry *Entry) {
+	dst := w.Header()
+	for k, values := range entry.Header {
+		for _, v := range values {
+			dst.Add(k, v)
+		}
+	}
+	w.WriteHeader(entry.StatusCode)
+	w.Write(entry.Body)
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyFoldsInVaryHeadersOnceRecorded(t *testing.T) {
+	c := New(1024, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	base := c.Key(r)
+	if base != "GET example.com/a" {
+		t.Fatalf("expected unvaried key, got %q", base)
+	}
+
+	c.RecordVary(r, http.Header{"Vary": []string{"Accept-Encoding"}})
+
+	varied := c.Key(r)
+	if varied == base {
+		t.Fatalf("expected key to change once Vary is recorded, got %q for both", varied)
+	}
+	if varied != "GET example.com/a|Accept-Encoding=gzip" {
+		t.Fatalf("unexpected varied key %q", varied)
+	}
+}
+
+func TestKnownVaryDistinguishesUnseenFromNoVary(t *testing.T) {
+	c := New(1024, 0)
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+
+	if c.KnownVary(r) {
+		t.Fatalf("expected KnownVary to be false before any response is recorded")
+	}
+
+	c.RecordVary(r, http.Header{})
+
+	if !c.KnownVary(r) {
+		t.Fatalf("expected KnownVary to be true once a response (even with no Vary header) is recorded")
+	}
+}
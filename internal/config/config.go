@@ -1,21 +1,199 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Backends []string `yaml:"backends"`
-	Address  string   `yaml:"address" env:"ADDRESS" env-default:"localhost:8080"`
-	LogLevel string   `yaml:"log_level" env:"LOG_LEVEL" env-default:"DEBUG"`
+	Backends         []BackendConfig        `yaml:"backends" json:"backends"`
+	Address          string                 `yaml:"address" json:"address" env:"ADDRESS" env-default:"localhost:8080"`
+	LogLevel         string                 `yaml:"log_level" json:"log_level" env:"LOG_LEVEL" env-default:"DEBUG"`
+	Algorithm        string                 `yaml:"algorithm" json:"algorithm" env:"ALGORITHM" env-default:"roundrobin"`
+	Cache            CacheConfig            `yaml:"cache" json:"cache"`
+	OutlierDetection OutlierDetectionConfig `yaml:"outlier_detection" json:"outlier_detection"`
+	Retry            RetryConfig            `yaml:"retry" json:"retry"`
+	// Routes selects a backend pool per request by host and path prefix.
+	// Requests matching no route fall back to the top-level Backends pool.
+	Routes []Route `yaml:"routes" json:"routes"`
 }
 
-func MustLoad(configPath string) Config {
+// Route binds a host/path-prefix match to its own backend pool and
+// algorithm. Host may be a wildcard ("*.example.com"); an empty Host
+// matches any host.
+type Route struct {
+	Host        string          `yaml:"host" json:"host"`
+	PathPrefix  string          `yaml:"path_prefix" json:"path_prefix"`
+	StripPrefix bool            `yaml:"strip_prefix" json:"strip_prefix"`
+	Backends    []BackendConfig `yaml:"backends" json:"backends"`
+	Algorithm   string          `yaml:"algorithm" json:"algorithm" env-default:"roundrobin"`
+}
+
+// BackendConfig describes one upstream backend. It unmarshals from either a
+// plain URL string (the original config format) or a mapping with weight,
+// connection-limit and health-check overrides.
+type BackendConfig struct {
+	URL        string `yaml:"url" json:"url"`
+	Weight     int    `yaml:"weight" json:"weight"`
+	MaxConns   int    `yaml:"max_conns" json:"max_conns"`
+	HealthPath string `yaml:"health_path" json:"health_path"`
+}
+
+// UnmarshalYAML accepts both `- http://host:port` and
+// `- url: http://host:port` / `weight: 2` forms so existing configs keep
+// working unchanged.
+func (b *BackendConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var url string
+		if err := value.Decode(&url); err != nil {
+			return err
+		}
+		b.URL = url
+		b.Weight = 1
+		return nil
+	}
+
+	type plain BackendConfig
+	var p plain
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*b = BackendConfig(p)
+	if b.Weight <= 0 {
+		b.Weight = 1
+	}
+	return nil
+}
+
+// CacheConfig controls the response cache consulted by balancer.LoadBalancer
+// before a request is dispatched to a backend.
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled" env-default:"false"`
+	// MaxBytes caps total bytes of cached response data (headers + body).
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes" env-default:"67108864"`
+	// DefaultTTL is used when a response carries no Cache-Control max-age
+	// or Expires header.
+	DefaultTTL time.Duration `yaml:"default_ttl" json:"default_ttl" env-default:"60s"`
+	// Methods lists the HTTP methods eligible for caching; defaults to
+	// GET and HEAD when empty.
+	Methods []string `yaml:"methods" json:"methods"`
+	// RespectHeaders lets an upstream response's Cache-Control max-age or
+	// Expires header set its TTL rather than always using DefaultTTL. Vary
+	// is honored whether or not this is set.
+	RespectHeaders bool `yaml:"respect_headers" json:"respect_headers" env-default:"true"`
+}
+
+// OutlierDetectionConfig controls passive outlier ejection: a backend is
+// pulled out of rotation once its recent failure rate crosses a threshold,
+// without waiting for the next active health check.
+type OutlierDetectionConfig struct {
+	// Consecutive5xx trips the breaker after this many failures in a row,
+	// regardless of FailureRatio/MinRequests.
+	Consecutive5xx int `yaml:"consecutive_5xx" json:"consecutive_5xx" env-default:"5"`
+	// FailureRatio is the fraction of recent requests that must fail
+	// (after MinRequests have been observed) to trip the breaker.
+	FailureRatio float64 `yaml:"failure_ratio" json:"failure_ratio" env-default:"0.5"`
+	// MinRequests is the minimum sample size before FailureRatio is
+	// evaluated.
+	MinRequests int `yaml:"min_requests" json:"min_requests" env-default:"20"`
+	// BaseEjectionTime is how long a backend is ejected for on its first
+	// trip; each subsequent trip doubles the cool-down up to
+	// MaxEjectionTime.
+	BaseEjectionTime time.Duration `yaml:"base_ejection_time" json:"base_ejection_time" env-default:"30s"`
+	MaxEjectionTime  time.Duration `yaml:"max_ejection_time" json:"max_ejection_time" env-default:"5m"`
+	// LatencyBudget, if set, counts a response slower than this as a
+	// failure for outlier detection purposes.
+	LatencyBudget time.Duration `yaml:"latency_budget" json:"latency_budget"`
+}
+
+// RetryConfig bounds how hard balancer.LoadBalancer will retry a request
+// against other backends before giving up.
+type RetryConfig struct {
+	// MaxRetries is the number of additional backends a request may be
+	// retried against after its first attempt fails.
+	MaxRetries int `yaml:"max_retries" json:"max_retries" env-default:"2"`
+	// MaxRetryBodyBytes caps how much of a request body is buffered for
+	// replay on retry; requests with non-idempotent methods and a larger
+	// body are not retried.
+	MaxRetryBodyBytes int64 `yaml:"max_retry_body_bytes" json:"max_retry_body_bytes" env-default:"65536"`
+	// ConnsPerBackend bounds in-flight requests to a single backend via a
+	// buffered semaphore; <= 0 means unlimited. A backend's own MaxConns,
+	// when set, takes precedence over this default.
+	ConnsPerBackend int `yaml:"conns_per_backend" json:"conns_per_backend" env-default:"0"`
+}
+
+// readConfig reads and parses the YAML file at path into a Config.
+func readConfig(path string) (Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readEnv parses a Config from environment variables alone, applying the
+// same env-default values readConfig's YAML loader does.
+//
+// Backends and Routes are slices of structs, which cleanenv has no flat env
+// representation for, so they're read separately from the BACKENDS_JSON and
+// ROUTES_JSON variables (JSON, using the same json tags Config already
+// carries for HTTPProvider) when set.
+func readEnv() (Config, error) {
+	var cfg Config
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if raw := os.Getenv("BACKENDS_JSON"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.Backends); err != nil {
+			return Config{}, fmt.Errorf("parse BACKENDS_JSON: %w", err)
+		}
+	}
+	if raw := os.Getenv("ROUTES_JSON"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.Routes); err != nil {
+			return Config{}, fmt.Errorf("parse ROUTES_JSON: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// MustLoad loads configuration from configPath and returns a FileProvider
+// watching that file for subsequent changes. It exits the process if the
+// initial load fails.
+func MustLoad(configPath string) ConfigProvider {
+	cfg, err := readConfig(configPath)
+	if err != nil {
 		log.Fatalf("cannot read config %q: %s", configPath, err)
 	}
-	return cfg
+	return &FileProvider{path: configPath, current: cfg, log: slog.Default()}
+}
+
+// MustLoadEnv loads configuration from the process environment and returns
+// an EnvProvider re-checking it every interval. It exits the process if the
+// initial load fails.
+func MustLoadEnv(interval time.Duration) ConfigProvider {
+	p, err := NewEnvProvider(interval, slog.Default())
+	if err != nil {
+		log.Fatalf("cannot read config from environment: %s", err)
+	}
+	return p
+}
+
+// MustLoadHTTP loads configuration by fetching url and returns an
+// HTTPProvider re-polling it every interval. It exits the process if the
+// initial fetch fails.
+func MustLoadHTTP(url string, interval time.Duration) ConfigProvider {
+	p, err := NewHTTPProvider(url, interval, slog.Default())
+	if err != nil {
+		log.Fatalf("cannot read config from %q: %s", url, err)
+	}
+	return p
 }
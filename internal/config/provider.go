@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigProvider supplies the current configuration and streams updates as
+// the underlying source changes, so a process can pick up new backends,
+// routing rules or tuning parameters without a restart.
+type ConfigProvider interface {
+	// Current returns the most recently loaded Config.
+	Current() Config
+	// Watch returns a channel that receives a new Config each time the
+	// source changes. It is closed when ctx is done or the provider gives
+	// up watching (e.g. the watched file can't be opened).
+	Watch(ctx context.Context) <-chan Config
+}
+
+// FileProvider re-reads a YAML config file whenever fsnotify reports a
+// write to it.
+type FileProvider struct {
+	path string
+	log  *slog.Logger
+
+	mu      sync.Mutex
+	current Config
+}
+
+// NewFileProvider loads path and returns a FileProvider ready to Watch it.
+func NewFileProvider(path string, log *slog.Logger) (*FileProvider, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileProvider{path: path, log: log, current: cfg}, nil
+}
+
+// SetLogger replaces the logger used for watch diagnostics. Useful when a
+// FileProvider is constructed by MustLoad before the application logger
+// (itself sourced from the config) exists.
+func (p *FileProvider) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
+func (p *FileProvider) Current() Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+func (p *FileProvider) Watch(ctx context.Context) <-chan Config {
+	out := make(chan Config)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		p.log.Error("failed to start config file watcher", "error", err)
+		close(out)
+		return out
+	}
+	if err := watcher.Add(p.path); err != nil {
+		p.log.Error("failed to watch config file", "path", p.path, "error", err)
+		watcher.Close()
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer watcher.Close()
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+
+				cfg, err := readConfig(p.path)
+				if err != nil {
+					p.log.Error("failed to reload config", "path", p.path, "error", err)
+					continue
+				}
+
+				p.mu.Lock()
+				p.current = cfg
+				p.mu.Unlock()
+
+				select {
+				case out <- cfg:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				p.log.Error("config file watcher error", "error", err)
+			}
+		}
+	}()
+
+	return out
+}
+
+// EnvProvider re-reads configuration from the process environment on a
+// fixed interval. It exists for parity with FileProvider/HTTPProvider in
+// setups where env vars are refreshed out from under a long-running
+// process (e.g. a mounted env file); most deployments are better served by
+// a plain restart.
+type EnvProvider struct {
+	interval time.Duration
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	current Config
+}
+
+// NewEnvProvider reads the current environment and returns an EnvProvider
+// that re-checks it every interval. It fails if no backends are configured
+// (via BACKENDS_JSON), rather than returning a provider that can never
+// route a request.
+func NewEnvProvider(interval time.Duration, log *slog.Logger) (*EnvProvider, error) {
+	cfg, err := readEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, fmt.Errorf("no backends configured: set BACKENDS_JSON")
+	}
+	return &EnvProvider{interval: interval, log: log, current: cfg}, nil
+}
+
+// SetLogger replaces the logger used for watch diagnostics. Useful when an
+// EnvProvider is constructed by MustLoadEnv before the application logger
+// (itself sourced from the config) exists.
+func (p *EnvProvider) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
+func (p *EnvProvider) Current() Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+func (p *EnvProvider) Watch(ctx context.Context) <-chan Config {
+	out := make(chan Config)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cfg, err := readEnv()
+				if err != nil {
+					p.log.Error("failed to reload config from environment", "error", err)
+					continue
+				}
+
+				p.mu.Lock()
+				changed := !reflect.DeepEqual(cfg, p.current)
+				p.current = cfg
+				p.mu.Unlock()
+
+				if !changed {
+					continue
+				}
+
+				select {
+				case out <- cfg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// HTTPProvider polls a URL for a JSON-encoded Config on a fixed interval.
+type HTTPProvider struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	current Config
+}
+
+// NewHTTPProvider performs an initial fetch from url and returns an
+// HTTPProvider that re-polls it every interval.
+func NewHTTPProvider(url string, interval time.Duration, log *slog.Logger) (*HTTPProvider, error) {
+	p := &HTTPProvider{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+	}
+
+	cfg, err := p.fetch(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	p.current = cfg
+	return p, nil
+}
+
+// SetLogger replaces the logger used for watch diagnostics. Useful when an
+// HTTPProvider is constructed by MustLoadHTTP before the application logger
+// (itself sourced from the config) exists.
+func (p *HTTPProvider) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
+func (p *HTTPProvider) Current() Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+func (p *HTTPProvider) Watch(ctx context.Context) <-chan Config {
+	out := make(chan Config)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cfg, err := p.fetch(ctx)
+				if err != nil {
+					p.log.Error("failed to poll config endpoint", "url", p.url, "error", err)
+					continue
+				}
+
+				p.mu.Lock()
+				changed := !reflect.DeepEqual(cfg, p.current)
+				p.current = cfg
+				p.mu.Unlock()
+
+				if !changed {
+					continue
+				}
+
+				select {
+				case out <- cfg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func (p *HTTPProvider) fetch(ctx context.Context) (Config, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return Config{}, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Config{}, err
+	}
+	defer resp.Body.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testProviderLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestFileProviderWatchReloadsOnWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	initial := "algorithm: roundrobin\nbackends:\n  - http://a\n"
+	if err := os.WriteFile(path, []byte(initial), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	p, err := NewFileProvider(path, testProviderLogger())
+	if err != nil {
+		t.Fatalf("NewFileProvider: %v", err)
+	}
+	if got := p.Current().Algorithm; got != "roundrobin" {
+		t.Fatalf("expected initial algorithm roundrobin, got %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := p.Watch(ctx)
+
+	updated := "algorithm: leastconnections\nbackends:\n  - http://a\n"
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		t.Fatalf("rewrite config: %v", err)
+	}
+
+	select {
+	case cfg := <-updates:
+		if cfg.Algorithm != "leastconnections" {
+			t.Fatalf("expected reloaded algorithm leastconnections, got %q", cfg.Algorithm)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+
+	if got := p.Current().Algorithm; got != "leastconnections" {
+		t.Fatalf("expected Current() to reflect the reload, got %q", got)
+	}
+}
+
+func TestNewEnvProviderFailsWithoutBackends(t *testing.T) {
+	if _, err := NewEnvProvider(time.Minute, testProviderLogger()); err == nil {
+		t.Fatalf("expected NewEnvProvider to fail when BACKENDS_JSON is unset")
+	}
+}
+
+func TestNewEnvProviderParsesBackendsJSON(t *testing.T) {
+	t.Setenv("BACKENDS_JSON", `[{"url":"http://a","weight":2},{"url":"http://b"}]`)
+	t.Setenv("ALGORITHM", "leastconnections")
+
+	p, err := NewEnvProvider(time.Minute, testProviderLogger())
+	if err != nil {
+		t.Fatalf("NewEnvProvider: %v", err)
+	}
+
+	cfg := p.Current()
+	if cfg.Algorithm != "leastconnections" {
+		t.Fatalf("expected ALGORITHM to be honored, got %q", cfg.Algorithm)
+	}
+	if len(cfg.Backends) != 2 {
+		t.Fatalf("expected 2 backends from BACKENDS_JSON, got %d", len(cfg.Backends))
+	}
+	if cfg.Backends[0].URL != "http://a" || cfg.Backends[0].Weight != 2 {
+		t.Fatalf("unexpected first backend: %+v", cfg.Backends[0])
+	}
+	if cfg.Backends[1].URL != "http://b" {
+		t.Fatalf("unexpected second backend: %+v", cfg.Backends[1])
+	}
+}
@@ -0,0 +1,133 @@
+// Package router dispatches requests to one of several backend pools based
+// on the request's Host and URL path, so a single process can front
+// multiple upstream pools behind host- and path-based rules.
+package router
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Route binds a host/path-prefix match to the handler that should serve it.
+// Host supports a leading wildcard segment ("*.example.com"); an empty Host
+// matches any host. Handler is typically a *balancer.LoadBalancer, but any
+// http.Handler works.
+type Route struct {
+	Host        string
+	PathPrefix  string
+	StripPrefix bool
+	Handler     http.Handler
+}
+
+// Router implements http.Handler, matching requests against Routes by host
+// and longest path-prefix, falling back to a default handler when nothing
+// matches. It also stamps every request with an X-Request-Id (generating
+// one if absent) and X-Forwarded-For/X-Forwarded-Proto headers.
+type Router struct {
+	routes   []Route
+	fallback http.Handler
+	log      *slog.Logger
+}
+
+// New builds a Router. Among routes whose host matches, the longest path
+// prefix wins; on equal prefix length the route earlier in routes wins.
+// fallback serves any request no route matches.
+func New(routes []Route, fallback http.Handler, log *slog.Logger) *Router {
+	return &Router{routes: routes, fallback: fallback, log: log}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	r.Header.Set("X-Request-Id", requestID)
+	w.Header().Set("X-Request-Id", requestID)
+	r.Header.Set("X-Forwarded-For", forwardedFor(r))
+	r.Header.Set("X-Forwarded-Proto", forwardedProto(r))
+
+	r = r.WithContext(ContextWithRequestID(r.Context(), requestID))
+
+	route := rt.match(r)
+	if route == nil {
+		rt.log.Debug("no route matched, using default pool", "request_id", requestID, "host", r.Host, "path", r.URL.Path)
+		rt.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	if route.StripPrefix && route.PathPrefix != "" {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, route.PathPrefix)
+		if r.URL.Path == "" {
+			r.URL.Path = "/"
+		}
+	}
+
+	route.Handler.ServeHTTP(w, r)
+}
+
+// match returns the route with the longest matching path prefix among
+// those whose host pattern matches r.Host, or nil if none match.
+func (rt *Router) match(r *http.Request) *Route {
+	host := stripPort(r.Host)
+
+	var best *Route
+	bestPrefixLen := -1
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		if !hostMatches(route.Host, host) {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			continue
+		}
+		if len(route.PathPrefix) > bestPrefixLen {
+			best = route
+			bestPrefixLen = len(route.PathPrefix)
+		}
+	}
+	return best
+}
+
+// hostMatches reports whether host satisfies pattern. An empty pattern
+// matches any host; a pattern of the form "*.example.com" matches
+// "example.com"'s subdomains (but not "example.com" itself).
+func hostMatches(pattern, host string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+rest)
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+func stripPort(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
+
+func forwardedFor(r *http.Request) string {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		return prior + ", " + clientIP
+	}
+	return clientIP
+}
+
+func forwardedProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	return "http"
+}
@@ -0,0 +1,134 @@
+package router
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func handlerNamed(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", name)
+		w.Header().Set("X-Path", r.URL.Path)
+	})
+}
+
+func TestRouterMatchesHostExactly(t *testing.T) {
+	rt := New([]Route{
+		{Host: "a.example.com", Handler: handlerNamed("a")},
+		{Host: "b.example.com", Handler: handlerNamed("b")},
+	}, handlerNamed("default"), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://b.example.com/", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Handler"); got != "b" {
+		t.Fatalf("expected route b to handle request, got %q", got)
+	}
+}
+
+func TestRouterWildcardHostMatch(t *testing.T) {
+	rt := New([]Route{
+		{Host: "*.example.com", Handler: handlerNamed("wildcard")},
+	}, handlerNamed("default"), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Handler"); got != "wildcard" {
+		t.Fatalf("expected wildcard route to match subdomain, got %q", got)
+	}
+
+	reqBare := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	recBare := httptest.NewRecorder()
+	rt.ServeHTTP(recBare, reqBare)
+
+	if got := recBare.Header().Get("X-Handler"); got != "default" {
+		t.Fatalf("expected bare domain to fall back to default, got %q", got)
+	}
+}
+
+func TestRouterLongestPathPrefixWins(t *testing.T) {
+	rt := New([]Route{
+		{Host: "example.com", PathPrefix: "/api", Handler: handlerNamed("api")},
+		{Host: "example.com", PathPrefix: "/api/v2", Handler: handlerNamed("api-v2")},
+	}, handlerNamed("default"), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/v2/users", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Handler"); got != "api-v2" {
+		t.Fatalf("expected longest prefix route to win, got %q", got)
+	}
+}
+
+func TestRouterStripsPrefixWhenConfigured(t *testing.T) {
+	rt := New([]Route{
+		{Host: "example.com", PathPrefix: "/api", StripPrefix: true, Handler: handlerNamed("api")},
+	}, handlerNamed("default"), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/users", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Path"); got != "/users" {
+		t.Fatalf("expected prefix to be stripped, got path %q", got)
+	}
+}
+
+func TestRouterFallsBackWhenNoRouteMatches(t *testing.T) {
+	rt := New([]Route{
+		{Host: "a.example.com", Handler: handlerNamed("a")},
+	}, handlerNamed("default"), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://unrelated.test/", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Handler"); got != "default" {
+		t.Fatalf("expected default pool to handle unmatched host, got %q", got)
+	}
+}
+
+func TestRouterGeneratesRequestIDWhenMissing(t *testing.T) {
+	var gotID string
+	rt := New(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = RequestIDFromContext(r.Context())
+	}), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if gotID == "" {
+		t.Fatalf("expected a request id to be generated")
+	}
+	if got := rec.Header().Get("X-Request-Id"); got != gotID {
+		t.Fatalf("expected response header to echo request id, got %q want %q", got, gotID)
+	}
+}
+
+func TestRouterPreservesExistingRequestID(t *testing.T) {
+	var gotID string
+	rt := New(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = RequestIDFromContext(r.Context())
+	}), testLogger())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("X-Request-Id", "client-supplied-id")
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if gotID != "client-supplied-id" {
+		t.Fatalf("expected existing request id to be preserved, got %q", gotID)
+	}
+}